@@ -0,0 +1,187 @@
+package ec2_productinfo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CacheMode controls whether a CachedProductInfoer is allowed to fall through to the wrapped ProductInfoer.
+type CacheMode int
+
+const (
+	// CacheModeReadWrite serves cached results when present and fresh, otherwise fetches from the wrapped
+	// ProductInfoer and records the result to disk ("record mode").
+	CacheModeReadWrite CacheMode = iota
+	// CacheModeReplayOnly never calls the wrapped ProductInfoer; it errors when no fixture is on disk. Useful
+	// for air-gapped environments and pinning tests to a known pricing snapshot ("replay mode").
+	CacheModeReplayOnly
+)
+
+// CachedProductInfoer decorates a ProductInfoer with a TTL'd, file-backed cache of its results, so recommenders
+// can run against on-disk fixtures instead of hitting AWS on every call.
+type CachedProductInfoer struct {
+	ProductInfoer
+	// Partition is mixed into the cache key so fixtures for different partitions don't collide.
+	Partition string
+	// Dir is the directory fixtures are read from and written to, one file per region/attribute combo.
+	Dir string
+	// TTL is how long a fixture is considered fresh. A zero TTL means fixtures never expire.
+	TTL time.Duration
+	// Mode selects between read-write ("record") and replay-only behavior.
+	Mode CacheMode
+}
+
+// NewCachedProductInfoer decorates wrapped with a file-backed cache rooted at dir.
+func NewCachedProductInfoer(wrapped ProductInfoer, partition, dir string, ttl time.Duration, mode CacheMode) *CachedProductInfoer {
+	return &CachedProductInfoer{
+		ProductInfoer: wrapped,
+		Partition:     partition,
+		Dir:           dir,
+		TTL:           ttl,
+		Mode:          mode,
+	}
+}
+
+type attrValuesCacheEntry struct {
+	FetchedAt time.Time  `json:"fetchedAt"`
+	Values    AttrValues `json:"values"`
+}
+
+type productsCacheEntry struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Vms       []Ec2Vm   `json:"vms"`
+}
+
+func (c *CachedProductInfoer) GetAttributeValues(attribute string) (AttrValues, error) {
+	path := c.pathFor("attributeValues", attribute)
+
+	if entry, ok := c.readAttrValues(path, false); ok {
+		logrus.Debugf("serving %s values from cache: %s", attribute, path)
+		return entry.Values, nil
+	}
+	if c.Mode == CacheModeReplayOnly {
+		return nil, fmt.Errorf("%w: no cached attribute values for %s at %s", ErrNotFound, attribute, path)
+	}
+
+	values, err := c.ProductInfoer.GetAttributeValues(attribute)
+	if err != nil {
+		if errors.Is(err, ErrThrottled) {
+			if entry, ok := c.readAttrValues(path, true); ok {
+				logrus.Warnf("serving stale %s values from cache after throttling: %s", attribute, path)
+				return entry.Values, nil
+			}
+		}
+		return nil, err
+	}
+	c.write(path, attrValuesCacheEntry{FetchedAt: time.Now(), Values: values})
+	return values, nil
+}
+
+func (c *CachedProductInfoer) GetProducts(regionId string, attrKey string, attrValue AttrValue) ([]Ec2Vm, error) {
+	path := c.pathFor("products", regionId, attrKey, attrValue.StrValue)
+
+	if entry, ok := c.readProducts(path, false); ok {
+		logrus.Debugf("serving products from cache [region=%s, %s=%s]: %s", regionId, attrKey, attrValue.StrValue, path)
+		return entry.Vms, nil
+	}
+	if c.Mode == CacheModeReplayOnly {
+		return nil, fmt.Errorf("%w: no cached products for [region=%s, %s=%s] at %s", ErrNotFound, regionId, attrKey, attrValue.StrValue, path)
+	}
+
+	vms, err := c.ProductInfoer.GetProducts(regionId, attrKey, attrValue)
+	if err != nil && !errors.Is(err, ErrPartial) {
+		if errors.Is(err, ErrThrottled) {
+			if entry, ok := c.readProducts(path, true); ok {
+				logrus.Warnf("serving stale products from cache after throttling [region=%s, %s=%s]: %s", regionId, attrKey, attrValue.StrValue, path)
+				return entry.Vms, nil
+			}
+		}
+		return nil, err
+	}
+	// ErrPartial still carries usable vms (all but the malformed entries), so it's worth caching too
+	c.write(path, productsCacheEntry{FetchedAt: time.Now(), Vms: vms})
+	return vms, err
+}
+
+// pathUnsafeFilenameChars matches anything that isn't safe to put directly in a filename
+var pathUnsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// pathFor computes the fixture file path for a cache entry identified by partition plus the given key parts.
+// The parts are embedded in the filename in readable form (so an operator can tell e.g. eu-west-1's instance
+// type fixture apart from us-east-1's at a glance) with a short hash suffix to keep entries unique and
+// filesystem-safe even when a part contains unusual characters.
+func (c *CachedProductInfoer) pathFor(parts ...string) string {
+	readable := pathUnsafeFilenameChars.ReplaceAllString(strings.Join(append([]string{c.Partition}, parts...), "_"), "_")
+
+	h := sha1.New()
+	h.Write([]byte(c.Partition))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	suffix := hex.EncodeToString(h.Sum(nil))[:8]
+
+	return filepath.Join(c.Dir, fmt.Sprintf("%s-%s.json", readable, suffix))
+}
+
+// readAttrValues loads the fixture at path. When ignoreTTL is true, a stale entry is still returned -
+// used to fall back to stale data when the live fetch was throttled rather than failing outright.
+func (c *CachedProductInfoer) readAttrValues(path string, ignoreTTL bool) (attrValuesCacheEntry, bool) {
+	var entry attrValuesCacheEntry
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		logrus.Warnf("couldn't parse cache file %s: %s", path, err.Error())
+		return entry, false
+	}
+	if !ignoreTTL && c.TTL > 0 && time.Since(entry.FetchedAt) > c.TTL {
+		return entry, false
+	}
+	return entry, true
+}
+
+// readProducts loads the fixture at path. When ignoreTTL is true, a stale entry is still returned - used to
+// fall back to stale data when the live fetch was throttled rather than failing outright.
+func (c *CachedProductInfoer) readProducts(path string, ignoreTTL bool) (productsCacheEntry, bool) {
+	var entry productsCacheEntry
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		logrus.Warnf("couldn't parse cache file %s: %s", path, err.Error())
+		return entry, false
+	}
+	if !ignoreTTL && c.TTL > 0 && time.Since(entry.FetchedAt) > c.TTL {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *CachedProductInfoer) write(path string, v interface{}) {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logrus.Warnf("couldn't marshal cache entry for %s: %s", path, err.Error())
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.Warnf("couldn't create cache dir for %s: %s", path, err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		logrus.Warnf("couldn't write cache file %s: %s", path, err.Error())
+	}
+}
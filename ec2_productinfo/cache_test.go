@@ -0,0 +1,171 @@
+package ec2_productinfo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubProductInfoer is a minimal ProductInfoer used to drive CachedProductInfoer without touching AWS.
+type stubProductInfoer struct {
+	attrValues AttrValues
+	attrErr    error
+	vms        []Ec2Vm
+	vmsErr     error
+	calls      int
+}
+
+func (s *stubProductInfoer) GetAttributeValues(attribute string) (AttrValues, error) {
+	s.calls++
+	return s.attrValues, s.attrErr
+}
+
+func (s *stubProductInfoer) GetProducts(regionId string, attrKey string, attrValue AttrValue) ([]Ec2Vm, error) {
+	s.calls++
+	return s.vms, s.vmsErr
+}
+
+func (s *stubProductInfoer) GetRegions() map[string]string {
+	return nil
+}
+
+func TestPathForIsReadableAndStable(t *testing.T) {
+	c := &CachedProductInfoer{Partition: "aws", Dir: "/tmp/fixtures"}
+
+	path := c.pathFor("products", "eu-west-1", Cpu, "4")
+	if !strings.Contains(path, "aws_products_eu-west-1_vcpu_4") {
+		t.Errorf("pathFor() = %q, want a readable name containing the key parts", path)
+	}
+	if !strings.HasSuffix(path, ".json") {
+		t.Errorf("pathFor() = %q, want a .json fixture", path)
+	}
+	if got := c.pathFor("products", "eu-west-1", Cpu, "4"); got != path {
+		t.Errorf("pathFor() is not stable across calls: %q != %q", got, path)
+	}
+
+	// Distinct partitions must not collide, since fixtures are shared across partitions via the same Dir.
+	other := &CachedProductInfoer{Partition: "aws-cn", Dir: "/tmp/fixtures"}
+	if got := other.pathFor("products", "eu-west-1", Cpu, "4"); got == path {
+		t.Errorf("pathFor() collided across partitions: %q", got)
+	}
+}
+
+func TestPathForSanitizesUnsafeCharacters(t *testing.T) {
+	c := &CachedProductInfoer{Partition: "aws", Dir: "/tmp/fixtures"}
+	path := c.pathFor("attributeValues", "some/weird key")
+	if strings.ContainsAny(filepathBase(path), "/") {
+		t.Errorf("pathFor() = %q, expected no path separators in the filename", path)
+	}
+}
+
+// filepathBase avoids importing path/filepath just for this one assertion.
+func filepathBase(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func TestGetProductsServesFreshCacheWithoutCallingWrapped(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubProductInfoer{vms: []Ec2Vm{{Type: "m5.xlarge"}}}
+	c := NewCachedProductInfoer(stub, "aws", dir, time.Hour, CacheModeReadWrite)
+
+	vms, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"})
+	if err != nil {
+		t.Fatalf("unexpected error on first call: %s", err)
+	}
+	if len(vms) != 1 || vms[0].Type != "m5.xlarge" {
+		t.Fatalf("unexpected vms on first call: %v", vms)
+	}
+	if stub.calls != 1 {
+		t.Fatalf("expected exactly one call to the wrapped infoer, got %d", stub.calls)
+	}
+
+	vms, err = c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"})
+	if err != nil {
+		t.Fatalf("unexpected error on cached call: %s", err)
+	}
+	if len(vms) != 1 || vms[0].Type != "m5.xlarge" {
+		t.Fatalf("unexpected vms on cached call: %v", vms)
+	}
+	if stub.calls != 1 {
+		t.Errorf("expected the second call to be served from cache, wrapped infoer called %d times", stub.calls)
+	}
+}
+
+func TestGetProductsRefetchesAfterTTLExpiry(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubProductInfoer{vms: []Ec2Vm{{Type: "m5.xlarge"}}}
+	c := NewCachedProductInfoer(stub, "aws", dir, time.Nanosecond, CacheModeReadWrite)
+
+	if _, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"}); err != nil {
+		t.Fatalf("unexpected error on first call: %s", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"}); err != nil {
+		t.Fatalf("unexpected error on second call: %s", err)
+	}
+	if stub.calls != 2 {
+		t.Errorf("expected the expired entry to trigger a refetch, wrapped infoer called %d times", stub.calls)
+	}
+}
+
+func TestGetProductsServesStaleCacheOnThrottle(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubProductInfoer{vms: []Ec2Vm{{Type: "m5.xlarge"}}}
+	c := NewCachedProductInfoer(stub, "aws", dir, time.Nanosecond, CacheModeReadWrite)
+
+	if _, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"}); err != nil {
+		t.Fatalf("unexpected error priming the cache: %s", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	stub.vmsErr = fmt.Errorf("%w: too many requests", ErrThrottled)
+	vms, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"})
+	if err != nil {
+		t.Fatalf("expected stale data to be served without an error, got: %s", err)
+	}
+	if len(vms) != 1 || vms[0].Type != "m5.xlarge" {
+		t.Errorf("expected stale vms to be returned on throttle, got %v", vms)
+	}
+}
+
+func TestGetProductsReplayOnlyErrNotFoundWhenUncached(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubProductInfoer{vms: []Ec2Vm{{Type: "m5.xlarge"}}}
+	c := NewCachedProductInfoer(stub, "aws", dir, 0, CacheModeReplayOnly)
+
+	_, err := c.GetProducts("eu-west-1", Cpu, AttrValue{StrValue: "4"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound in replay mode with no fixture, got %v", err)
+	}
+	if stub.calls != 0 {
+		t.Errorf("expected replay mode to never call the wrapped infoer, called %d times", stub.calls)
+	}
+}
+
+func TestGetAttributeValuesReplayOnlyServesExistingFixture(t *testing.T) {
+	dir := t.TempDir()
+	recordStub := &stubProductInfoer{attrValues: AttrValues{{StrValue: "4", Value: 4}}}
+	recorder := NewCachedProductInfoer(recordStub, "aws", dir, 0, CacheModeReadWrite)
+	if _, err := recorder.GetAttributeValues(Cpu); err != nil {
+		t.Fatalf("unexpected error priming the fixture: %s", err)
+	}
+
+	replayStub := &stubProductInfoer{}
+	replayer := NewCachedProductInfoer(replayStub, "aws", dir, 0, CacheModeReplayOnly)
+	values, err := replayer.GetAttributeValues(Cpu)
+	if err != nil {
+		t.Fatalf("unexpected error replaying fixture: %s", err)
+	}
+	if len(values) != 1 || values[0].StrValue != "4" {
+		t.Errorf("unexpected replayed values: %v", values)
+	}
+	if replayStub.calls != 0 {
+		t.Errorf("expected replay mode to never call the wrapped infoer, called %d times", replayStub.calls)
+	}
+}
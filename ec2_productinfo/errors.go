@@ -0,0 +1,14 @@
+package ec2_productinfo
+
+import "errors"
+
+// Typed errors returned by ProductInfoer implementations, so upstream recommenders can decide whether to
+// serve stale cached data or propagate the failure.
+var (
+	// ErrThrottled indicates the pricing API rejected the request due to rate limiting
+	ErrThrottled = errors.New("pricing api request was throttled")
+	// ErrNotFound indicates the requested attribute or product data could not be found
+	ErrNotFound = errors.New("requested pricing data not found")
+	// ErrPartial indicates only part of a multi-page or multi-region request could be parsed or fetched
+	ErrPartial = errors.New("partial pricing data returned")
+)
@@ -1,18 +1,44 @@
 package ec2_productinfo
 
 import (
+	"errors"
 	"fmt"
 
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/pricing"
 	"github.com/sirupsen/logrus"
 )
 
+// defaultSpotPriceRefreshInterval is used when AwsInfoer.SpotPriceRefreshInterval is left unset
+const defaultSpotPriceRefreshInterval = 5 * time.Minute
+
+// defaultMultiRegionConcurrency is used when AwsInfoer.MultiRegionConcurrency is left unset
+const defaultMultiRegionConcurrency = 8
+
+// retryer settings for the pricing client: the pricing API throttles aggressively, so retries use
+// exponential backoff with jitter rather than giving up on the first ThrottlingException.
+const (
+	pricingRetryerMinDelay   = 500 * time.Millisecond
+	pricingRetryerMaxDelay   = 30 * time.Second
+	pricingRetryerMaxRetries = 8
+)
+
+// ZonePrice couples an availability zone with a price observed in that zone
+type ZonePrice struct {
+	Zone  string
+	Price float64
+}
+
 // ProductInfoer gathers operations for retrieving cloud provider information for recommendations
 // it also decouples provider api specific code from the recommender
 type ProductInfoer interface {
@@ -29,12 +55,36 @@ type ProductInfoer interface {
 // AwsInfoer encapsulates the data and operations needed to access external resources
 type AwsInfoer struct {
 	session *session.Session
+	// Partition is the AWS partition this infoer operates against, one of endpoints.AwsPartitionID,
+	// endpoints.AwsCnPartitionID or endpoints.AwsUsGovPartitionID. Defaults to endpoints.AwsPartitionID.
+	Partition string
+	// SpotPriceRefreshInterval configures how often StartSpotPriceRefresh polls for fresh spot prices.
+	// Defaults to defaultSpotPriceRefreshInterval when left unset.
+	SpotPriceRefreshInterval time.Duration
+	// MultiRegionConcurrency bounds how many regions GetProductsMultiRegion queries at once.
+	// Defaults to defaultMultiRegionConcurrency.
+	MultiRegionConcurrency int
+
+	ec2Mu       sync.Mutex
+	ec2Services map[string]*ec2.EC2
 	// embedded interface to ensure operations are implemented (todo research if this can be avoided)
 	ProductInfoer
 }
 
-// NewAwsInfoer encapsulates the creation of a wrapper instance
+// NewAwsInfoer encapsulates the creation of a wrapper instance for the default "aws" partition
 func NewAwsInfoer() (*AwsInfoer, error) {
+	return NewAwsInfoerForPartition(endpoints.AwsPartitionID)
+}
+
+// NewAwsInfoerForPartition encapsulates the creation of a wrapper instance bound to a specific AWS partition,
+// e.g. endpoints.AwsPartitionID ("aws"), endpoints.AwsCnPartitionID ("aws-cn") or endpoints.AwsUsGovPartitionID
+// ("aws-us-gov")
+func NewAwsInfoerForPartition(partition string) (*AwsInfoer, error) {
+	if !isKnownPartition(partition) {
+		return &AwsInfoer{}, fmt.Errorf("unknown partition %q, must be one of %s, %s, %s", partition,
+			endpoints.AwsPartitionID, endpoints.AwsCnPartitionID, endpoints.AwsUsGovPartitionID)
+	}
+
 	newSession, err := session.NewSession(&aws.Config{})
 
 	if err != nil {
@@ -42,14 +92,28 @@ func NewAwsInfoer() (*AwsInfoer, error) {
 	}
 
 	return &AwsInfoer{
-		session: newSession,
+		session:     newSession,
+		Partition:   partition,
+		ec2Services: make(map[string]*ec2.EC2),
 	}, nil
 }
 
+// isKnownPartition reports whether partition is one of the AWS partitions this package supports, so a typo
+// or unrecognized value errors out instead of silently falling back to the commercial partition in
+// partition() below - a data-residency footgun for GovCloud/China callers.
+func isKnownPartition(partition string) bool {
+	switch partition {
+	case endpoints.AwsPartitionID, endpoints.AwsCnPartitionID, endpoints.AwsUsGovPartitionID:
+		return true
+	default:
+		return false
+	}
+}
+
 func (wr *AwsInfoer) GetAttributeValues(attribute string) (AttrValues, error) {
 	apiValues, err := wr.pricingService().GetAttributeValues(wr.newAttributeValuesInput(attribute))
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 	var values AttrValues
 	for _, v := range apiValues.AttributeValues {
@@ -70,50 +134,195 @@ func (wr *AwsInfoer) GetAttributeValues(attribute string) (AttrValues, error) {
 func (wr *AwsInfoer) GetProducts(regionId string, attrKey string, attrValue AttrValue) ([]Ec2Vm, error) {
 
 	var vms []Ec2Vm
+	var skipped int
 	logrus.Debugf("Getting available instance types from AWS API. [region=%s, %s=%s]", regionId, attrKey, attrValue.StrValue)
 
-	products, err := wr.pricingService().GetProducts(wr.newGetProductsInput(regionId, attrKey, attrValue))
-
+	// the pricing API caps GetProducts at 100 results per call, so larger instance families need pagination
+	err := wr.pricingService().GetProductsPages(wr.newGetProductsInput(regionId, attrKey, attrValue),
+		func(page *pricing.GetProductsOutput, lastPage bool) bool {
+			for _, price := range page.PriceList {
+				vm, ok := vmFromPriceEntry(price)
+				if !ok {
+					skipped++
+					continue
+				}
+				vms = append(vms, vm)
+			}
+			return true
+		})
 	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	logrus.Debugf("found vms [%s=%s]: %#v", attrKey, attrValue.StrValue, vms)
+	if skipped > 0 {
+		logrus.Warnf("skipped %d malformed price entries [region=%s, %s=%s]", skipped, regionId, attrKey, attrValue.StrValue)
+		return vms, ErrPartial
+	}
+	return vms, nil
+}
+
+// GetProductsWithSpotPrices is GetProducts plus spot price enrichment: it additionally fetches spot prices
+// for the returned instance types and fills in each Ec2Vm's SpotPrice field (keyed by availability zone), so
+// callers can compare spot against on-demand cost per VM. This issues an extra EC2 DescribeSpotPriceHistory
+// call on top of the pricing API call GetProducts already makes, so it's opt-in rather than folded into
+// GetProducts itself - callers that only need on-demand data (e.g. a region-parallel refresh) shouldn't pay
+// for it on every call.
+func (wr *AwsInfoer) GetProductsWithSpotPrices(regionId string, attrKey string, attrValue AttrValue) ([]Ec2Vm, error) {
+	vms, err := wr.GetProducts(regionId, attrKey, attrValue)
+	if err != nil && !errors.Is(err, ErrPartial) {
 		return nil, err
 	}
-	for _, price := range products.PriceList {
-		var onDemandPrice float64
-		// TODO: this is unsafe, check for nil values if needed
-		instanceType := price["product"].(map[string]interface{})["attributes"].(map[string]interface{})["instanceType"].(string)
-		cpusStr := price["product"].(map[string]interface{})["attributes"].(map[string]interface{})[Cpu].(string)
-		memStr := price["product"].(map[string]interface{})["attributes"].(map[string]interface{})[Memory].(string)
-		var gpus float64
-		if price["product"].(map[string]interface{})["attributes"].(map[string]interface{})["gpu"] != nil {
-			gpuStr := price["product"].(map[string]interface{})["attributes"].(map[string]interface{})["gpu"].(string)
-			gpus, _ = strconv.ParseFloat(gpuStr, 32)
+
+	if len(vms) > 0 {
+		types := make([]string, len(vms))
+		for i, vm := range vms {
+			types[i] = vm.Type
 		}
-		onDemandTerm := price["terms"].(map[string]interface{})["OnDemand"].(map[string]interface{})
-		for _, term := range onDemandTerm {
-			priceDimensions := term.(map[string]interface{})["priceDimensions"].(map[string]interface{})
-			for _, dimension := range priceDimensions {
-				odPriceStr := dimension.(map[string]interface{})["pricePerUnit"].(map[string]interface{})["USD"].(string)
-				onDemandPrice, _ = strconv.ParseFloat(odPriceStr, 32)
+
+		spotPrices, spotErr := wr.GetSpotPrices(regionId, types)
+		if spotErr != nil {
+			logrus.Warnf("couldn't fetch spot prices [region=%s]: %s", regionId, spotErr.Error())
+		} else {
+			for i := range vms {
+				zonePrices, ok := spotPrices[vms[i].Type]
+				if !ok {
+					continue
+				}
+				byZone := make(map[string]float64, len(zonePrices))
+				for _, zp := range zonePrices {
+					byZone[zp.Zone] = zp.Price
+				}
+				vms[i].SpotPrice = byZone
 			}
 		}
-		cpus, _ := strconv.ParseFloat(cpusStr, 32)
-		mem, _ := strconv.ParseFloat(strings.Split(memStr, " ")[0], 32)
-		vm := Ec2Vm{
-			Type:          instanceType,
-			OnDemandPrice: onDemandPrice,
-			Cpus:          cpus,
-			Mem:           mem,
-			Gpus:          gpus,
+	}
+
+	return vms, err
+}
+
+// vmFromPriceEntry parses a single raw pricing API price list entry into an Ec2Vm, returning ok=false if the
+// entry is missing its instance type and can't be used.
+func vmFromPriceEntry(price map[string]interface{}) (Ec2Vm, bool) {
+	product, _ := price["product"].(map[string]interface{})
+	attrs, _ := product["attributes"].(map[string]interface{})
+
+	instanceType := attrString(attrs, "instanceType")
+	if instanceType == "" {
+		return Ec2Vm{}, false
+	}
+
+	cpus, _ := strconv.ParseFloat(attrString(attrs, Cpu), 32)
+	mem, _ := strconv.ParseFloat(strings.Split(attrString(attrs, Memory), " ")[0], 32)
+	var gpus float64
+	if gpuStr := attrString(attrs, "gpu"); gpuStr != "" {
+		gpus, _ = strconv.ParseFloat(gpuStr, 32)
+	}
+
+	var onDemandPrice float64
+	onDemandTerm, _ := price["terms"].(map[string]interface{})["OnDemand"].(map[string]interface{})
+	for _, term := range onDemandTerm {
+		priceDimensions, _ := term.(map[string]interface{})["priceDimensions"].(map[string]interface{})
+		for _, dimension := range priceDimensions {
+			pricePerUnit, _ := dimension.(map[string]interface{})["pricePerUnit"].(map[string]interface{})
+			odPriceStr, _ := pricePerUnit["USD"].(string)
+			onDemandPrice, _ = strconv.ParseFloat(odPriceStr, 32)
 		}
-		vms = append(vms, vm)
 	}
-	logrus.Debugf("found vms [%s=%s]: %#v", attrKey, attrValue.StrValue, vms)
-	return vms, nil
+
+	return Ec2Vm{
+		Type:                   instanceType,
+		OnDemandPrice:          onDemandPrice,
+		Cpus:                   cpus,
+		Mem:                    mem,
+		Gpus:                   gpus,
+		Storage:                attrString(attrs, "storage"),
+		NetworkPerformance:     attrString(attrs, "networkPerformance"),
+		PhysicalProcessor:      attrString(attrs, "physicalProcessor"),
+		ClockSpeed:             attrString(attrs, "clockSpeed"),
+		CurrentGeneration:      strings.EqualFold(attrString(attrs, "currentGeneration"), "Yes"),
+		InstanceFamily:         attrString(attrs, "instanceFamily"),
+		ProcessorArchitecture:  architectureFromAttrs(attrs),
+		DedicatedEbsThroughput: attrString(attrs, "dedicatedEbsThroughput"),
+	}, true
+}
+
+// architectureFromAttrs derives "x86_64"/"arm64"/"i386" from the product attributes. The pricing API's own
+// processorArchitecture attribute only reports word size ("32-bit"/"64-bit") and doesn't distinguish Graviton
+// from x86_64, so Graviton is detected from physicalProcessor instead.
+func architectureFromAttrs(attrs map[string]interface{}) string {
+	if strings.Contains(attrString(attrs, "physicalProcessor"), "Graviton") {
+		return "arm64"
+	}
+	switch attrString(attrs, "processorArchitecture") {
+	case "64-bit":
+		return "x86_64"
+	case "32-bit":
+		return "i386"
+	default:
+		return ""
+	}
+}
+
+// GetProductsMultiRegion fans out GetProducts across the given regions with bounded concurrency. Errors are
+// kept per-region rather than failing the whole call, so a single throttled region doesn't wipe out an
+// entire refresh cycle.
+func (wr *AwsInfoer) GetProductsMultiRegion(regionIds []string, attrKey string, attrValue AttrValue) (map[string][]Ec2Vm, map[string]error) {
+	concurrency := wr.MultiRegionConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultMultiRegionConcurrency
+	}
+
+	type regionResult struct {
+		regionId string
+		vms      []Ec2Vm
+		err      error
+	}
+
+	sem := make(chan struct{}, concurrency)
+	results := make(chan regionResult, len(regionIds))
+	var wg sync.WaitGroup
+
+	for _, regionId := range regionIds {
+		wg.Add(1)
+		go func(regionId string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			vms, err := wr.GetProducts(regionId, attrKey, attrValue)
+			results <- regionResult{regionId: regionId, vms: vms, err: err}
+		}(regionId)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	vmsByRegion := make(map[string][]Ec2Vm)
+	errsByRegion := make(map[string]error)
+	for r := range results {
+		mergeRegionResult(vmsByRegion, errsByRegion, r.regionId, r.vms, r.err)
+	}
+	return vmsByRegion, errsByRegion
+}
+
+// mergeRegionResult folds one region's GetProducts outcome into the accumulators shared across the fan-out
+// in GetProductsMultiRegion. Pulled out as a pure function so the aggregation rule - a hard failure excludes
+// the region's vms, but ErrPartial keeps them alongside the recorded error - can be unit tested directly.
+func mergeRegionResult(vmsByRegion map[string][]Ec2Vm, errsByRegion map[string]error, regionId string, vms []Ec2Vm, err error) {
+	if err != nil {
+		errsByRegion[regionId] = err
+		// ErrPartial still carries usable vms (all but the malformed entries), unlike a hard failure
+		if !errors.Is(err, ErrPartial) {
+			return
+		}
+	}
+	vmsByRegion[regionId] = vms
 }
 
 func (wr *AwsInfoer) GetRegion(id string) *endpoints.Region {
-	awsp := endpoints.AwsPartition()
-	for _, r := range awsp.Regions() {
+	for _, r := range wr.partition().Regions() {
 		if r.ID() == id {
 			return &r
 		}
@@ -121,8 +330,164 @@ func (wr *AwsInfoer) GetRegion(id string) *endpoints.Region {
 	return nil
 }
 
+// partition resolves the endpoints.Partition this infoer operates against, defaulting to the commercial
+// "aws" partition when Partition is left unset.
+func (wr *AwsInfoer) partition() endpoints.Partition {
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() == wr.Partition {
+			return p
+		}
+	}
+	return endpoints.AwsPartition()
+}
+
+// pricingRegionForPartition returns the region hosting the pricing API endpoint for the given partition, as
+// the pricing service is only available from a single region per partition.
+func pricingRegionForPartition(partition string) string {
+	switch partition {
+	case endpoints.AwsCnPartitionID:
+		return endpoints.CnNorth1RegionID
+	case endpoints.AwsUsGovPartitionID:
+		return endpoints.UsGovWest1RegionID
+	default:
+		return endpoints.UsEast1RegionID
+	}
+}
+
+// pricingLocationOverrides corrects the pricing API "location" filter value for specific regions, in case
+// AWS's bulk pricing location name ever diverges from endpoints.Region.Description() for that region. It's
+// empty today: GetRegion already resolves regions from the matching per-partition endpoints metadata (see
+// partition()), and that partition-specific Description() is known to already match the pricing API's
+// location strings for AWS China and GovCloud regions, same as it does for the commercial partition. This
+// map exists as a documented escape hatch if a specific region's pricing location is ever found to diverge.
+var pricingLocationOverrides = map[string]string{}
+
+// pricingLocation returns the pricing API "location" filter value for the given region, applying any
+// override above, otherwise falling back to its partition-aware Description().
+func pricingLocation(region *endpoints.Region) string {
+	if override, ok := pricingLocationOverrides[region.ID()]; ok {
+		return override
+	}
+	return region.Description()
+}
+
 func (wr *AwsInfoer) pricingService() *pricing.Pricing {
-	return pricing.New(wr.session, &aws.Config{Region: aws.String("us-east-1")})
+	return pricing.New(wr.session, &aws.Config{
+		Region: aws.String(pricingRegionForPartition(wr.Partition)),
+		Retryer: client.DefaultRetryer{
+			NumMaxRetries:    pricingRetryerMaxRetries,
+			MinRetryDelay:    pricingRetryerMinDelay,
+			MaxRetryDelay:    pricingRetryerMaxDelay,
+			MinThrottleDelay: pricingRetryerMinDelay,
+			MaxThrottleDelay: pricingRetryerMaxDelay,
+		},
+	})
+}
+
+// classifyError maps an AWS SDK error onto one of the package's typed errors, so callers can tell a
+// throttled request apart from one whose data genuinely doesn't exist.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "ThrottlingException", "RequestLimitExceeded":
+			return fmt.Errorf("%w: %s", ErrThrottled, aerr.Error())
+		case pricing.ErrCodeNotFoundException, pricing.ErrCodeInvalidParameterException:
+			return fmt.Errorf("%w: %s", ErrNotFound, aerr.Error())
+		}
+	}
+	return err
+}
+
+// ec2Service returns a per-region EC2 client, creating and caching one on first use. Spot prices (unlike
+// on-demand prices) aren't exposed through the pricing API, which is why this needs its own regional client
+// rather than reusing pricingService().
+func (wr *AwsInfoer) ec2Service(regionId string) *ec2.EC2 {
+	wr.ec2Mu.Lock()
+	defer wr.ec2Mu.Unlock()
+
+	if svc, ok := wr.ec2Services[regionId]; ok {
+		return svc
+	}
+	if wr.ec2Services == nil {
+		wr.ec2Services = make(map[string]*ec2.EC2)
+	}
+	svc := ec2.New(wr.session, &aws.Config{Region: aws.String(regionId)})
+	wr.ec2Services[regionId] = svc
+	return svc
+}
+
+// GetSpotPrices retrieves the most recent Linux/UNIX spot price per availability zone for each of the given
+// instance types in the given region.
+func (wr *AwsInfoer) GetSpotPrices(regionId string, instanceTypes []string) (map[string][]ZonePrice, error) {
+	logrus.Debugf("Getting spot price history from AWS API. [region=%s, types=%v]", regionId, instanceTypes)
+
+	var types []*string
+	for _, it := range instanceTypes {
+		types = append(types, aws.String(it))
+	}
+
+	history, err := wr.ec2Service(regionId).DescribeSpotPriceHistory(&ec2.DescribeSpotPriceHistoryInput{
+		InstanceTypes:       types,
+		ProductDescriptions: []*string{aws.String("Linux/UNIX")},
+	})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	seen := make(map[string]bool)
+	prices := make(map[string][]ZonePrice)
+	// the history is returned most-recent-first, so the first entry seen per type/AZ pair is the latest one
+	for _, sp := range history.SpotPriceHistory {
+		if sp.InstanceType == nil || sp.AvailabilityZone == nil || sp.SpotPrice == nil {
+			continue
+		}
+		key := *sp.InstanceType + "/" + *sp.AvailabilityZone
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		price, err := strconv.ParseFloat(*sp.SpotPrice, 64)
+		if err != nil {
+			logrus.Warnf("couldn't parse spot price [type=%s, az=%s]: %s", *sp.InstanceType, *sp.AvailabilityZone, err.Error())
+			continue
+		}
+		prices[*sp.InstanceType] = append(prices[*sp.InstanceType], ZonePrice{
+			Zone:  *sp.AvailabilityZone,
+			Price: price,
+		})
+	}
+	logrus.Debugf("found spot prices [region=%s]: %#v", regionId, prices)
+	return prices, nil
+}
+
+// StartSpotPriceRefresh periodically fetches fresh spot prices for the given region and instance types at
+// SpotPriceRefreshInterval, invoking onUpdate with each successful result. It blocks until stopCh is closed,
+// so callers should run it in its own goroutine.
+func (wr *AwsInfoer) StartSpotPriceRefresh(regionId string, instanceTypes []string, onUpdate func(map[string][]ZonePrice), stopCh <-chan struct{}) {
+	interval := wr.SpotPriceRefreshInterval
+	if interval <= 0 {
+		interval = defaultSpotPriceRefreshInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			prices, err := wr.GetSpotPrices(regionId, instanceTypes)
+			if err != nil {
+				logrus.Warnf("failed to refresh spot prices [region=%s]: %s", regionId, err.Error())
+				continue
+			}
+			onUpdate(prices)
+		case <-stopCh:
+			return
+		}
+	}
 }
 
 // newAttributeValuesInput assembles a GetAttributeValuesInput instance for querying the provider
@@ -147,7 +512,7 @@ func (wr *AwsInfoer) newGetProductsInput(regionId string, attrKey string, attrVa
 			{
 				Type:  aws.String("TERM_MATCH"),
 				Field: aws.String("location"),
-				Value: aws.String(wr.GetRegion(regionId).Description()),
+				Value: aws.String(pricingLocation(wr.GetRegion(regionId))),
 			},
 			{
 				Type:  aws.String("TERM_MATCH"),
@@ -170,8 +535,43 @@ func (wr *AwsInfoer) newGetProductsInput(regionId string, attrKey string, attrVa
 
 func (wr *AwsInfoer) GetRegions() map[string]string {
 	regionIdMap := make(map[string]string)
-	for key, region := range endpoints.AwsPartition().Regions() {
+	for key, region := range wr.partition().Regions() {
 		regionIdMap[key] = region.ID()
 	}
 	return regionIdMap
 }
+
+// attrString safely reads a string-valued product attribute, returning "" if it's absent or of another type
+// instead of panicking, since the pricing API doesn't report every attribute for every instance type.
+func attrString(attrs map[string]interface{}, key string) string {
+	if v, ok := attrs[key]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// FilterByArchitecture returns the subset of vms matching the given processor architecture, e.g. "x86_64" or
+// "arm64" (Graviton-based instances).
+func FilterByArchitecture(vms []Ec2Vm, architecture string) []Ec2Vm {
+	var filtered []Ec2Vm
+	for _, vm := range vms {
+		if vm.ProcessorArchitecture == architecture {
+			filtered = append(filtered, vm)
+		}
+	}
+	return filtered
+}
+
+// FilterInstanceStoreBacked returns the subset of vms backed by local instance-store (e.g. NVMe) rather than
+// EBS-only storage.
+func FilterInstanceStoreBacked(vms []Ec2Vm) []Ec2Vm {
+	var filtered []Ec2Vm
+	for _, vm := range vms {
+		if vm.Storage != "" && !strings.EqualFold(vm.Storage, "EBS only") {
+			filtered = append(filtered, vm)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,214 @@
+package ec2_productinfo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/pricing"
+)
+
+func TestVmFromPriceEntryCurrentGeneration(t *testing.T) {
+	price := map[string]interface{}{
+		"product": map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"instanceType":      "m5.xlarge",
+				Cpu:                 "4",
+				Memory:              "16 GiB",
+				"currentGeneration": "Yes",
+			},
+		},
+		"terms": map[string]interface{}{},
+	}
+
+	vm, ok := vmFromPriceEntry(price)
+	if !ok {
+		t.Fatalf("expected vmFromPriceEntry to succeed")
+	}
+	if !vm.CurrentGeneration {
+		t.Errorf("expected CurrentGeneration to be true for currentGeneration=Yes, got false")
+	}
+}
+
+func TestArchitectureFromAttrs(t *testing.T) {
+	cases := []struct {
+		name              string
+		physicalProcessor string
+		processorArch     string
+		want              string
+	}{
+		{"graviton is arm64 regardless of reported word size", "AWS Graviton2 Processor", "64-bit", "arm64"},
+		{"64-bit non-graviton is x86_64", "Intel Xeon Platinum 8175", "64-bit", "x86_64"},
+		{"32-bit non-graviton is i386", "Intel Xeon Family", "32-bit", "i386"},
+		{"unrecognized word size is empty", "Intel Xeon Family", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attrs := map[string]interface{}{
+				"physicalProcessor":     c.physicalProcessor,
+				"processorArchitecture": c.processorArch,
+			}
+			if got := architectureFromAttrs(attrs); got != c.want {
+				t.Errorf("architectureFromAttrs() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestVmFromPriceEntryNotCurrentGeneration(t *testing.T) {
+	price := map[string]interface{}{
+		"product": map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"instanceType":      "m1.small",
+				Cpu:                 "1",
+				Memory:              "1.7 GiB",
+				"currentGeneration": "No",
+			},
+		},
+		"terms": map[string]interface{}{},
+	}
+
+	vm, ok := vmFromPriceEntry(price)
+	if !ok {
+		t.Fatalf("expected vmFromPriceEntry to succeed")
+	}
+	if vm.CurrentGeneration {
+		t.Errorf("expected CurrentGeneration to be false for currentGeneration=No, got true")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil passes through", nil, nil},
+		{"throttling exception becomes ErrThrottled", awserr.New("ThrottlingException", "slow down", nil), ErrThrottled},
+		{"request limit exceeded becomes ErrThrottled", awserr.New("RequestLimitExceeded", "slow down", nil), ErrThrottled},
+		{"not found exception becomes ErrNotFound", awserr.New(pricing.ErrCodeNotFoundException, "no such sku", nil), ErrNotFound},
+		{"invalid parameter becomes ErrNotFound", awserr.New(pricing.ErrCodeInvalidParameterException, "bad filter", nil), ErrNotFound},
+		{"unrelated aws error passes through unwrapped", awserr.New("AccessDenied", "nope", nil), nil},
+		{"non-aws error passes through unwrapped", errors.New("boom"), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := classifyError(c.err)
+			switch {
+			case c.err == nil:
+				if got != nil {
+					t.Errorf("classifyError(nil) = %v, want nil", got)
+				}
+			case c.want != nil:
+				if !errors.Is(got, c.want) {
+					t.Errorf("classifyError(%v) = %v, want wrapped %v", c.err, got, c.want)
+				}
+			default:
+				if got != c.err {
+					t.Errorf("classifyError(%v) = %v, want passthrough", c.err, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPricingLocation(t *testing.T) {
+	region, ok := endpoints.AwsPartition().Regions()["eu-west-1"]
+	if !ok {
+		t.Fatalf("eu-west-1 not found in aws partition metadata")
+	}
+
+	if got, want := pricingLocation(&region), region.Description(); got != want {
+		t.Errorf("pricingLocation() = %q, want %q (Description())", got, want)
+	}
+
+	pricingLocationOverrides[region.ID()] = "EU (Ireland) override"
+	defer delete(pricingLocationOverrides, region.ID())
+
+	if got, want := pricingLocation(&region), "EU (Ireland) override"; got != want {
+		t.Errorf("pricingLocation() with override = %q, want %q", got, want)
+	}
+}
+
+func TestFilterByArchitecture(t *testing.T) {
+	vms := []Ec2Vm{
+		{Type: "m5.xlarge", ProcessorArchitecture: "x86_64"},
+		{Type: "m6g.xlarge", ProcessorArchitecture: "arm64"},
+		{Type: "a1.medium", ProcessorArchitecture: "arm64"},
+	}
+
+	got := FilterByArchitecture(vms, "arm64")
+	if len(got) != 2 || got[0].Type != "m6g.xlarge" || got[1].Type != "a1.medium" {
+		t.Errorf("FilterByArchitecture(arm64) = %v, want [m6g.xlarge a1.medium]", got)
+	}
+
+	if got := FilterByArchitecture(vms, "i386"); got != nil {
+		t.Errorf("FilterByArchitecture(i386) = %v, want nil", got)
+	}
+}
+
+func TestFilterInstanceStoreBacked(t *testing.T) {
+	vms := []Ec2Vm{
+		{Type: "m5.xlarge", Storage: "EBS only"},
+		{Type: "i3.xlarge", Storage: "1 x 950 NVMe SSD"},
+		{Type: "t3.micro", Storage: ""},
+	}
+
+	got := FilterInstanceStoreBacked(vms)
+	if len(got) != 1 || got[0].Type != "i3.xlarge" {
+		t.Errorf("FilterInstanceStoreBacked() = %v, want [i3.xlarge]", got)
+	}
+}
+
+func TestMergeRegionResult(t *testing.T) {
+	euVms := []Ec2Vm{{Type: "m5.xlarge"}}
+	usVms := []Ec2Vm{{Type: "m5.large"}}
+
+	vmsByRegion := make(map[string][]Ec2Vm)
+	errsByRegion := make(map[string]error)
+
+	mergeRegionResult(vmsByRegion, errsByRegion, "eu-west-1", euVms, nil)
+	mergeRegionResult(vmsByRegion, errsByRegion, "us-east-1", usVms, fmt.Errorf("%w: bad sku", ErrPartial))
+	mergeRegionResult(vmsByRegion, errsByRegion, "cn-north-1", nil, ErrThrottled)
+
+	if _, ok := vmsByRegion["eu-west-1"]; !ok {
+		t.Errorf("expected eu-west-1 vms to be kept on success")
+	}
+	if _, ok := errsByRegion["eu-west-1"]; ok {
+		t.Errorf("expected no error recorded for eu-west-1")
+	}
+
+	if _, ok := vmsByRegion["us-east-1"]; !ok {
+		t.Errorf("expected us-east-1 vms to be kept despite ErrPartial")
+	}
+	if !errors.Is(errsByRegion["us-east-1"], ErrPartial) {
+		t.Errorf("expected us-east-1 error to be ErrPartial, got %v", errsByRegion["us-east-1"])
+	}
+
+	if _, ok := vmsByRegion["cn-north-1"]; ok {
+		t.Errorf("expected cn-north-1 vms to be dropped on hard failure")
+	}
+	if !errors.Is(errsByRegion["cn-north-1"], ErrThrottled) {
+		t.Errorf("expected cn-north-1 error to be ErrThrottled, got %v", errsByRegion["cn-north-1"])
+	}
+}
+
+func TestIsKnownPartition(t *testing.T) {
+	cases := []struct {
+		partition string
+		want      bool
+	}{
+		{endpoints.AwsPartitionID, true},
+		{endpoints.AwsCnPartitionID, true},
+		{endpoints.AwsUsGovPartitionID, true},
+		{"aws-made-up", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := isKnownPartition(c.partition); got != c.want {
+			t.Errorf("isKnownPartition(%q) = %v, want %v", c.partition, got, c.want)
+		}
+	}
+}
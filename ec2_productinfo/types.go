@@ -0,0 +1,48 @@
+package ec2_productinfo
+
+// Attribute keys used when querying the AWS pricing API
+const (
+	Cpu    = "vcpu"
+	Memory = "memory"
+)
+
+// AttrValue represents an attribute value, as returned by the provider, coupled with its numeric representation
+// so it can be used directly in comparisons and sorting
+type AttrValue struct {
+	StrValue string
+	Value    float64
+}
+
+// AttrValues represents a slice of attribute values
+type AttrValues []AttrValue
+
+// Ec2Vm represents an ec2 instance type's attributes relevant for recommendations
+type Ec2Vm struct {
+	Type          string
+	OnDemandPrice float64
+	Cpus          float64
+	Mem           float64
+	Gpus          float64
+	// SpotPrice holds the most recently observed spot price per availability zone, keyed by AZ
+	SpotPrice map[string]float64
+
+	// Storage describes the instance's storage, e.g. "EBS only" or "2 x 900 NVMe SSD"
+	Storage string
+	// NetworkPerformance is the AWS-reported network performance tier, e.g. "Up to 10 Gigabit"
+	NetworkPerformance string
+	// PhysicalProcessor names the underlying CPU, e.g. "AWS Graviton2 Processor"
+	PhysicalProcessor string
+	// ClockSpeed is the AWS-reported processor clock speed, e.g. "2.5 GHz"
+	ClockSpeed string
+	// CurrentGeneration is true for instance types AWS still recommends for new workloads
+	CurrentGeneration bool
+	// InstanceFamily groups related instance types, e.g. "General purpose"
+	InstanceFamily string
+	// ProcessorArchitecture is one of "x86_64", "arm64" (Graviton) or "i386", or "" when the pricing API reports
+	// no recognised word size. The pricing API's own processorArchitecture attribute only reports word size
+	// ("32-bit"/"64-bit") and can't tell Graviton apart from x86_64, so arm64 is detected from PhysicalProcessor
+	// and the word size decides between x86_64 and i386 otherwise.
+	ProcessorArchitecture string
+	// DedicatedEbsThroughput is the guaranteed EBS bandwidth, e.g. "4750 Mbps"
+	DedicatedEbsThroughput string
+}